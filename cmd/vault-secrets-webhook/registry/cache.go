@@ -0,0 +1,177 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	imagev1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheTTL        = 5 * time.Minute
+	defaultCacheMaxEntries = 256
+)
+
+var (
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "vault_secrets_webhook",
+		Subsystem: "registry_cache",
+		Name:      "hits_total",
+		Help:      "Number of image config blob cache hits.",
+	})
+	cacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "vault_secrets_webhook",
+		Subsystem: "registry_cache",
+		Name:      "misses_total",
+		Help:      "Number of image config blob cache misses.",
+	})
+	cacheErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "vault_secrets_webhook",
+		Subsystem: "registry_cache",
+		Name:      "errors_total",
+		Help:      "Number of errors encountered while populating the image config blob cache.",
+	})
+)
+
+// blobCacheKey identifies a resolved (registry, repository, digest,
+// platform) image config blob. digest, not tag, so repeated pulls of the
+// same digest are free while a moving tag like :latest still tracks
+// upstream changes. os/arch are part of the key too: for a manifest
+// list/OCI index, digest is the list's own digest, identical across every
+// platform it contains, while the config resolved from it is per-platform.
+type blobCacheKey struct {
+	registry   string
+	repository string
+	digest     string
+	os         string
+	arch       string
+}
+
+type blobCacheEntry struct {
+	key       blobCacheKey
+	image     imagev1.Image
+	expiresAt time.Time
+}
+
+// blobCache is an in-process LRU cache of parsed image config blobs, with
+// a TTL on top so a stale entry eventually falls out regardless.
+type blobCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	elements   map[blobCacheKey]*list.Element
+	order      *list.List
+}
+
+func newBlobCache(ttl time.Duration, maxEntries int) *blobCache {
+	return &blobCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		elements:   make(map[blobCacheKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (c *blobCache) get(key blobCacheKey) (imagev1.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.elements[key]
+	if !ok {
+		cacheMissesTotal.Inc()
+		return imagev1.Image{}, false
+	}
+
+	entry := elem.Value.(*blobCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.elements, key)
+		cacheMissesTotal.Inc()
+		return imagev1.Image{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	cacheHitsTotal.Inc()
+	return entry.image, true
+}
+
+func (c *blobCache) set(key blobCacheKey, image imagev1.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.elements[key]; ok {
+		elem.Value.(*blobCacheEntry).image = image
+		elem.Value.(*blobCacheEntry).expiresAt = time.Now().Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&blobCacheEntry{key: key, image: image, expiresAt: time.Now().Add(c.ttl)})
+	c.elements[key] = elem
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.elements, oldest.Value.(*blobCacheEntry).key)
+	}
+}
+
+// Purge empties the cache. Exposed for tests.
+func (c *blobCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.elements = make(map[blobCacheKey]*list.Element)
+	c.order.Init()
+}
+
+func cacheTTLFromEnv() time.Duration {
+	if raw := os.Getenv("REGISTRY_CACHE_TTL"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil {
+			return d
+		}
+		logger.WithFields(log.Fields{"value": raw}).Info("invalid REGISTRY_CACHE_TTL, falling back to default")
+	}
+	return defaultCacheTTL
+}
+
+func cacheMaxEntriesFromEnv() int {
+	if raw := os.Getenv("REGISTRY_CACHE_MAX_ENTRIES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+		logger.WithFields(log.Fields{"value": raw}).Info("invalid REGISTRY_CACHE_MAX_ENTRIES, falling back to default")
+	}
+	return defaultCacheMaxEntries
+}
+
+var defaultBlobCache = newBlobCache(cacheTTLFromEnv(), cacheMaxEntriesFromEnv())
+
+// PurgeBlobCache empties the package-level image config blob cache.
+// Exposed for tests.
+func PurgeBlobCache() {
+	defaultBlobCache.Purge()
+}
@@ -0,0 +1,117 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+	"time"
+
+	imagev1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBlobCacheGetSet(t *testing.T) {
+	c := newBlobCache(time.Minute, 256)
+	key := blobCacheKey{registry: "docker.io", repository: "library/nginx", digest: "sha256:abc", os: "linux", arch: "amd64"}
+
+	if _, ok := c.get(key); ok {
+		t.Fatalf("get on empty cache should miss")
+	}
+
+	want := imagev1.Image{Config: imagev1.ImageConfig{Entrypoint: []string{"nginx"}}}
+	c.set(key, want)
+
+	got, ok := c.get(key)
+	if !ok {
+		t.Fatalf("expected a hit after set")
+	}
+	if len(got.Config.Entrypoint) != 1 || got.Config.Entrypoint[0] != "nginx" {
+		t.Errorf("got.Config.Entrypoint = %v, want [nginx]", got.Config.Entrypoint)
+	}
+}
+
+func TestBlobCacheKeyIncludesPlatform(t *testing.T) {
+	c := newBlobCache(time.Minute, 256)
+	base := blobCacheKey{registry: "docker.io", repository: "library/nginx", digest: "sha256:abc", os: "linux"}
+
+	c.set(blobCacheKey{registry: base.registry, repository: base.repository, digest: base.digest, os: base.os, arch: "amd64"}, imagev1.Image{Config: imagev1.ImageConfig{Cmd: []string{"amd64"}}})
+	c.set(blobCacheKey{registry: base.registry, repository: base.repository, digest: base.digest, os: base.os, arch: "arm64"}, imagev1.Image{Config: imagev1.ImageConfig{Cmd: []string{"arm64"}}})
+
+	amd64, ok := c.get(blobCacheKey{registry: base.registry, repository: base.repository, digest: base.digest, os: base.os, arch: "amd64"})
+	if !ok || amd64.Config.Cmd[0] != "amd64" {
+		t.Errorf("amd64 entry = %+v, ok = %v, want Cmd [amd64]", amd64, ok)
+	}
+
+	arm64, ok := c.get(blobCacheKey{registry: base.registry, repository: base.repository, digest: base.digest, os: base.os, arch: "arm64"})
+	if !ok || arm64.Config.Cmd[0] != "arm64" {
+		t.Errorf("arm64 entry = %+v, ok = %v, want Cmd [arm64]", arm64, ok)
+	}
+}
+
+func TestBlobCacheTTLExpiry(t *testing.T) {
+	c := newBlobCache(time.Millisecond, 256)
+	key := blobCacheKey{registry: "docker.io", repository: "library/nginx", digest: "sha256:abc", os: "linux", arch: "amd64"}
+
+	c.set(key, imagev1.Image{})
+
+	if _, ok := c.get(key); !ok {
+		t.Fatalf("expected a hit immediately after set")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := c.get(key); ok {
+		t.Errorf("expected the entry to have expired")
+	}
+}
+
+func TestBlobCacheLRUEviction(t *testing.T) {
+	c := newBlobCache(time.Minute, 2)
+
+	keyA := blobCacheKey{registry: "docker.io", repository: "a", digest: "sha256:a", os: "linux", arch: "amd64"}
+	keyB := blobCacheKey{registry: "docker.io", repository: "b", digest: "sha256:b", os: "linux", arch: "amd64"}
+	keyC := blobCacheKey{registry: "docker.io", repository: "c", digest: "sha256:c", os: "linux", arch: "amd64"}
+
+	c.set(keyA, imagev1.Image{})
+	c.set(keyB, imagev1.Image{})
+
+	// Touch A so B becomes the least recently used entry.
+	if _, ok := c.get(keyA); !ok {
+		t.Fatalf("expected a hit for keyA")
+	}
+
+	c.set(keyC, imagev1.Image{})
+
+	if _, ok := c.get(keyB); ok {
+		t.Errorf("expected keyB to have been evicted as the least recently used entry")
+	}
+	if _, ok := c.get(keyA); !ok {
+		t.Errorf("expected keyA to survive eviction")
+	}
+	if _, ok := c.get(keyC); !ok {
+		t.Errorf("expected keyC to survive eviction")
+	}
+}
+
+func TestBlobCachePurge(t *testing.T) {
+	c := newBlobCache(time.Minute, 256)
+	key := blobCacheKey{registry: "docker.io", repository: "library/nginx", digest: "sha256:abc", os: "linux", arch: "amd64"}
+	c.set(key, imagev1.Image{})
+
+	c.Purge()
+
+	if _, ok := c.get(key); ok {
+		t.Errorf("expected the cache to be empty after Purge")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// credHelperReply is the JSON document a docker-credential-<name> helper
+// writes to stdout in response to a `get` request.
+type credHelperReply struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// dockerHubHost is the canonical host used as the map key for any of the
+// DockerHub aliases Docker itself treats as equivalent.
+const dockerHubHost = "docker.io"
+
+// anyHost is the key a bare credsStore (no per-registry auths/credHelpers
+// entries at all) is merged under, since it applies regardless of host.
+const anyHost = "*"
+
+// credEntry is a single resolved (or resolvable) credential, merged from
+// one or more imagePullSecrets for a single normalized registry host.
+type credEntry struct {
+	Username string
+	Password string
+	Helper   string
+}
+
+// normalizeRegistryHost maps the DockerHub aliases Docker accepts in
+// config.json (and an empty host, meaning "implied DockerHub") onto a
+// single canonical key, stripping any scheme and path first - Docker
+// CLI-created secrets store the auths key as a full URL like
+// `https://index.docker.io/v1/`.
+func normalizeRegistryHost(host string) string {
+	host = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://"))
+	if i := strings.Index(host, "/"); i != -1 {
+		host = host[:i]
+	}
+
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubHost
+	default:
+		return host
+	}
+}
+
+// mergeDockerCreds folds dockerCreds into dest, keyed by normalized
+// registry host, for merging multiple imagePullSecrets into one lookup.
+// auths and credHelpers are walked independently since a registry can
+// appear in credHelpers with no matching auths entry (the canonical
+// ECR/GCR/ACR setup). A credsStore fallback is scoped to only the hosts
+// this dockerCreds itself contributed, so it can't override a host's real
+// credentials merged in from an earlier secret.
+func mergeDockerCreds(dest map[string]credEntry, dockerCreds DockerCreds) {
+	hosts := make(map[string]struct{}, len(dockerCreds.Auths)+len(dockerCreds.CredHelpers))
+
+	for host, auth := range dockerCreds.Auths {
+		normalized := normalizeRegistryHost(host)
+		entry := dest[normalized]
+		entry.Username = auth.Username
+		entry.Password = auth.Password
+		dest[normalized] = entry
+		hosts[normalized] = struct{}{}
+	}
+
+	for host, helper := range dockerCreds.CredHelpers {
+		normalized := normalizeRegistryHost(host)
+		entry := dest[normalized]
+		entry.Helper = helper
+		dest[normalized] = entry
+		hosts[normalized] = struct{}{}
+	}
+
+	if dockerCreds.CredsStore == "" {
+		return
+	}
+
+	if len(hosts) == 0 {
+		dest[anyHost] = credEntry{Helper: dockerCreds.CredsStore}
+		return
+	}
+
+	for host := range hosts {
+		entry := dest[host]
+		if entry.Helper == "" {
+			entry.Helper = dockerCreds.CredsStore
+			dest[host] = entry
+		}
+	}
+}
+
+// execCredentialHelper runs `docker-credential-<name> get`, feeding it
+// serverURL on stdin, and returns the username/password it replies with.
+func execCredentialHelper(name, serverURL string) (string, string, error) {
+	bin := fmt.Sprintf("docker-credential-%s", name)
+
+	cmd := exec.Command(bin, "get")
+	cmd.Stdin = bytes.NewBufferString(serverURL)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("cannot run %s: %s: %s", bin, err.Error(), stderr.String())
+	}
+
+	var reply credHelperReply
+	if err := json.Unmarshal(stdout.Bytes(), &reply); err != nil {
+		return "", "", fmt.Errorf("cannot unmarshal %s reply: %s", bin, err.Error())
+	}
+
+	return reply.Username, reply.Secret, nil
+}
@@ -0,0 +1,113 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+
+	dockerTypes "github.com/docker/docker/api/types"
+)
+
+func TestNormalizeRegistryHost(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{name: "empty host means implied DockerHub", host: "", want: dockerHubHost},
+		{name: "bare docker.io", host: "docker.io", want: dockerHubHost},
+		{name: "index.docker.io", host: "index.docker.io", want: dockerHubHost},
+		{name: "registry-1.docker.io", host: "registry-1.docker.io", want: dockerHubHost},
+		{
+			name: "kubectl create secret docker-registry default auths key",
+			host: "https://index.docker.io/v1/",
+			want: dockerHubHost,
+		},
+		{name: "scheme is stripped", host: "https://gcr.io", want: "gcr.io"},
+		{name: "host with port is left alone", host: "registry:5000", want: "registry:5000"},
+		{name: "host with path and port", host: "https://registry:5000/v2/", want: "registry:5000"},
+		{name: "mixed case is lowercased", host: "GCR.io", want: "gcr.io"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeRegistryHost(tt.host); got != tt.want {
+				t.Errorf("normalizeRegistryHost(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDockerCreds(t *testing.T) {
+	t.Run("credHelpers-only registry with no auths entry is still merged", func(t *testing.T) {
+		dest := map[string]credEntry{}
+		mergeDockerCreds(dest, DockerCreds{
+			CredHelpers: map[string]string{"public.ecr.aws": "ecr-login"},
+		})
+
+		entry, ok := dest["public.ecr.aws"]
+		if !ok {
+			t.Fatalf("expected an entry for public.ecr.aws, got %+v", dest)
+		}
+		if entry.Helper != "ecr-login" {
+			t.Errorf("entry.Helper = %q, want %q", entry.Helper, "ecr-login")
+		}
+	})
+
+	t.Run("auths and credHelpers for the same host are merged into one entry", func(t *testing.T) {
+		dest := map[string]credEntry{}
+		mergeDockerCreds(dest, DockerCreds{
+			Auths:       map[string]dockerTypes.AuthConfig{"https://index.docker.io/v1/": {Username: "user", Password: "pass"}},
+			CredHelpers: map[string]string{"docker.io": "desktop"},
+		})
+
+		entry, ok := dest[dockerHubHost]
+		if !ok {
+			t.Fatalf("expected an entry for %s, got %+v", dockerHubHost, dest)
+		}
+		if entry.Username != "user" || entry.Password != "pass" {
+			t.Errorf("entry = %+v, want username/password from auths", entry)
+		}
+		if entry.Helper != "desktop" {
+			t.Errorf("entry.Helper = %q, want %q", entry.Helper, "desktop")
+		}
+	})
+
+	t.Run("bare credsStore with no per-registry entries applies to any host", func(t *testing.T) {
+		dest := map[string]credEntry{}
+		mergeDockerCreds(dest, DockerCreds{CredsStore: "desktop"})
+
+		entry, ok := dest[anyHost]
+		if !ok {
+			t.Fatalf("expected an entry for %s, got %+v", anyHost, dest)
+		}
+		if entry.Helper != "desktop" {
+			t.Errorf("entry.Helper = %q, want %q", entry.Helper, "desktop")
+		}
+	})
+
+	t.Run("credsStore fills in as a fallback helper for hosts without one", func(t *testing.T) {
+		dest := map[string]credEntry{}
+		mergeDockerCreds(dest, DockerCreds{
+			Auths:      map[string]dockerTypes.AuthConfig{"https://index.docker.io/v1/": {Username: "user", Password: "pass"}},
+			CredsStore: "desktop",
+		})
+
+		entry := dest[dockerHubHost]
+		if entry.Helper != "desktop" {
+			t.Errorf("entry.Helper = %q, want %q", entry.Helper, "desktop")
+		}
+	})
+}
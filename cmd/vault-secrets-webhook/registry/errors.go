@@ -0,0 +1,30 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import "fmt"
+
+// CredentialsNotFoundError is returned by K8s.Load when the pod's
+// imagePullSecrets (and its ServiceAccount's) were read successfully but
+// none of them carry an entry for the image's registry. Callers can type
+// assert on it to distinguish "no creds configured" from a network or
+// API-server failure.
+type CredentialsNotFoundError struct {
+	Registry string
+}
+
+func (e *CredentialsNotFoundError) Error() string {
+	return fmt.Sprintf("no image pull credentials found for registry %q", e.Registry)
+}
@@ -0,0 +1,95 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultTag is used whenever an image reference carries neither a tag
+// nor a digest, matching the implicit `:latest` the Docker CLI assumes.
+const defaultTag = "latest"
+
+// Reference is a parsed container image reference, modeled on
+// github.com/distribution/reference: a registry host, a repository path
+// and either a tag, a digest, or both.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseContainerImage parses a container image string into a Reference,
+// handling digests (image@sha256:...), registry hosts with ports
+// (registry:5000/foo/bar), and untagged images (defaulted to :latest) -
+// unlike a plain strings.SplitN(image, ":", 2), which mishandles all three.
+func ParseContainerImage(image string) (Reference, error) {
+	if image == "" {
+		return Reference{}, fmt.Errorf("cannot parse empty image reference")
+	}
+
+	ref := Reference{}
+
+	remainder := image
+	if host := splitImageRegistryHost(remainder); host != "" {
+		ref.Registry = host
+		remainder = strings.TrimPrefix(remainder, host+"/")
+	}
+
+	// Split off the digest first so repo:tag@digest still gets its tag below.
+	beforeDigest := remainder
+	if at := strings.Index(remainder, "@"); at != -1 {
+		beforeDigest = remainder[:at]
+		ref.Digest = remainder[at+1:]
+		if !strings.Contains(ref.Digest, ":") {
+			return Reference{}, fmt.Errorf("invalid digest %q in image %s", ref.Digest, image)
+		}
+	}
+
+	if colon := strings.LastIndex(beforeDigest, ":"); colon != -1 && !strings.Contains(beforeDigest[colon:], "/") {
+		ref.Repository = beforeDigest[:colon]
+		ref.Tag = beforeDigest[colon+1:]
+	} else {
+		ref.Repository = beforeDigest
+	}
+
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("cannot find repository in image %s", image)
+	}
+
+	if ref.Registry == "" {
+		ref.Registry = dockerHubHost
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = "library/" + ref.Repository
+		}
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+
+	return ref, nil
+}
+
+// String renders the Reference back into `registry/repository:tag` (or
+// `registry/repository@digest` when a digest is present) form.
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return fmt.Sprintf("%s/%s@%s", r.Registry, r.Repository, r.Digest)
+	}
+	return fmt.Sprintf("%s/%s:%s", r.Registry, r.Repository, r.Tag)
+}
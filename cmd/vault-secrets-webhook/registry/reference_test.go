@@ -0,0 +1,95 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import "testing"
+
+func TestParseContainerImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		want    Reference
+		wantErr bool
+	}{
+		{
+			name:  "untagged single-name image defaults to latest and library/",
+			image: "nginx",
+			want:  Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:  "tagged image",
+			image: "nginx:1.19",
+			want:  Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.19"},
+		},
+		{
+			name:  "namespaced image on DockerHub",
+			image: "bitnami/nginx:1.19",
+			want:  Reference{Registry: "docker.io", Repository: "bitnami/nginx", Tag: "1.19"},
+		},
+		{
+			name:  "registry host with port",
+			image: "registry:5000/foo/bar",
+			want:  Reference{Registry: "registry:5000", Repository: "foo/bar", Tag: "latest"},
+		},
+		{
+			name:  "registry host with port and tag",
+			image: "registry:5000/foo/bar:v2",
+			want:  Reference{Registry: "registry:5000", Repository: "foo/bar", Tag: "v2"},
+		},
+		{
+			name:  "digest pin",
+			image: "gcr.io/distroless/static@sha256:1234567890123456789012345678901234567890123456789012345678901234",
+			want:  Reference{Registry: "gcr.io", Repository: "distroless/static", Digest: "sha256:1234567890123456789012345678901234567890123456789012345678901234"},
+		},
+		{
+			name:  "digest pin on DockerHub single-name image",
+			image: "busybox@sha256:1234567890123456789012345678901234567890123456789012345678901234",
+			want:  Reference{Registry: "docker.io", Repository: "library/busybox", Digest: "sha256:1234567890123456789012345678901234567890123456789012345678901234"},
+		},
+		{
+			name:  "tag and digest pin together",
+			image: "nginx:1.21@sha256:1234567890123456789012345678901234567890123456789012345678901234",
+			want:  Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.21", Digest: "sha256:1234567890123456789012345678901234567890123456789012345678901234"},
+		},
+		{
+			name:    "empty image",
+			image:   "",
+			wantErr: true,
+		},
+		{
+			name:    "invalid digest",
+			image:   "nginx@not-a-digest",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseContainerImage(tt.image)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseContainerImage(%q) expected an error, got %+v", tt.image, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseContainerImage(%q) returned unexpected error: %s", tt.image, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseContainerImage(%q) = %+v, want %+v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
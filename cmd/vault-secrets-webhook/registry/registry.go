@@ -15,16 +15,14 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"reflect"
 	"strings"
 
+	"github.com/containers/image/v5/docker"
+	imagelib "github.com/containers/image/v5/image"
 	dockerTypes "github.com/docker/docker/api/types"
-	"github.com/heroku/docker-registry-client/registry"
-	imagev1 "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -38,92 +36,137 @@ func init() {
 }
 
 type DockerCreds struct {
-	Auths map[string]dockerTypes.AuthConfig `json:"auths"`
+	Auths       map[string]dockerTypes.AuthConfig `json:"auths"`
+	CredsStore  string                            `json:"credsStore,omitempty"`
+	CredHelpers map[string]string                 `json:"credHelpers,omitempty"`
 }
 
-// GetImageBlob download image blob from registry
+// GetImageBlob resolves a container image's entrypoint and cmd through
+// containers/image, resolving against linux on the webhook's own
+// architecture. url is
+// the registry host the caller resolved (with or without a scheme).
 func GetImageBlob(url, username, password, image string) ([]string, []string, error) {
-	imageName, tag, err := ParseContainerImage(image)
+	nodeOS, nodeArch := resolveNodePlatform(nil)
+	return getImageBlobForPlatform(url, username, password, image, nodeOS, nodeArch)
+}
+
+// getImageBlobForPlatform is GetImageBlob's implementation, parameterized by
+// the target platform so GetEntrypointCmd can resolve against a pod's
+// NodeSelector/affinity instead of that default.
+func getImageBlobForPlatform(url, username, password, image, nodeOS, nodeArch string) ([]string, []string, error) {
+	ref, err := ParseContainerImage(image)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	registrySkipVerify := os.Getenv("REGISTRY_SKIP_VERIFY")
-
-	var hub *registry.Registry
+	host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://"), "/")
+	if host == "" {
+		host = ref.Registry
+	}
 
-	if registrySkipVerify == "true" {
-		hub, err = registry.NewInsecure(url, username, password)
+	refString := fmt.Sprintf("//%s/%s", host, ref.Repository)
+	if ref.Digest != "" {
+		refString += "@" + ref.Digest
 	} else {
-		hub, err = registry.New(url, username, password)
+		refString += ":" + ref.Tag
 	}
+
+	imgRef, err := docker.ParseReference(refString)
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot create client for registry: %s", err.Error())
+		return nil, nil, fmt.Errorf("cannot parse image reference %s: %s", refString, err.Error())
 	}
 
-	manifest, err := hub.ManifestV2(imageName, tag)
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot download manifest for image: %s", err.Error())
+	sysCtx := buildSystemContext(username, password, nodeOS, nodeArch)
+	ctx := context.Background()
+
+	// Resolve the digest with a cheap HEAD first, so a cache hit skips the
+	// full manifest GET that counts against registry pull-rate-limiting.
+	resolvedDigest := ref.Digest
+	if resolvedDigest == "" {
+		digest, err := docker.GetDigest(ctx, sysCtx, imgRef)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot resolve digest for image: %s", err.Error())
+		}
+		resolvedDigest = digest.String()
 	}
 
-	reader, err := hub.DownloadBlob(imageName, manifest.Config.Digest)
-	if reader != nil {
-		defer reader.Close()
+	// See blobCacheKey for why os/arch are part of the key too.
+	cacheKey := blobCacheKey{registry: host, repository: ref.Repository, digest: resolvedDigest, os: nodeOS, arch: nodeArch}
+	if imageMetadata, ok := defaultBlobCache.get(cacheKey); ok {
+		return imageMetadata.Config.Entrypoint, imageMetadata.Config.Cmd, nil
 	}
+
+	src, err := imgRef.NewImageSource(ctx, sysCtx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot download blob: %s", err.Error())
+		cacheErrorsTotal.Inc()
+		return nil, nil, fmt.Errorf("cannot create image source: %s", err.Error())
 	}
+	defer src.Close()
 
-	b, err := ioutil.ReadAll(reader)
+	manifestBytes, mediaType, err := src.GetManifest(ctx, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot read blob: %s", err.Error())
+		cacheErrorsTotal.Inc()
+		return nil, nil, fmt.Errorf("cannot download manifest for image: %s", err.Error())
 	}
 
-	logger.Info("downloaded blob len: ", len(b))
+	img, err := imagelib.FromSource(ctx, sysCtx, src)
+	if err != nil {
+		cacheErrorsTotal.Inc()
+		// List the platforms actually present, since containers/image's own
+		// "no compatible instance" error doesn't.
+		if platforms := availablePlatforms(manifestBytes, mediaType); len(platforms) > 0 {
+			return nil, nil, fmt.Errorf("cannot read image: %s (wanted %s/%s; available platforms: %s)", err.Error(), nodeOS, nodeArch, strings.Join(platforms, ", "))
+		}
+		return nil, nil, fmt.Errorf("cannot read image: %s", err.Error())
+	}
+	defer img.Close()
 
-	var imageMetadata imagev1.Image
-	err = json.Unmarshal(b, &imageMetadata)
+	imageMetadata, err := img.OCIConfig(ctx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot unmarshal BlobResponse JSON: %s", err.Error())
+		cacheErrorsTotal.Inc()
+		return nil, nil, fmt.Errorf("cannot read image config: %s", err.Error())
 	}
 
+	logger.Info("resolved image config for ", refString)
+
+	defaultBlobCache.set(cacheKey, *imageMetadata)
+
 	return imageMetadata.Config.Entrypoint, imageMetadata.Config.Cmd, nil
 }
 
-// ParseContainerImage returns image and tag
-func ParseContainerImage(image string) (string, string, error) {
-	split := strings.SplitN(image, ":", 2)
-
-	if len(split) <= 1 {
-		return "", "", fmt.Errorf("Cannot find tag for image %s", image)
+// splitImageRegistryHost returns the registry host of an image reference
+// (empty when none is present), using the Docker CLI's own heuristic: the
+// first path segment is a host only if it contains a "." or a ":", or is
+// exactly "localhost".
+func splitImageRegistryHost(image string) string {
+	firstSlash := strings.Index(image, "/")
+	if firstSlash == -1 {
+		return ""
 	}
 
-	imageName := split[0]
-	tag := split[1]
-
-	return imageName, tag, nil
-}
+	candidate := image[:firstSlash]
+	if candidate == "localhost" || strings.ContainsAny(candidate, ".:") {
+		return candidate
+	}
 
-func isDockerHub(registryAddress string) bool {
-	return strings.HasPrefix(registryAddress, "https://registry-1.docker.io") || strings.HasPrefix(registryAddress, "https://index.docker.io")
+	return ""
 }
 
 // GetEntrypointCmd returns entrypoint and command of container
-func GetEntrypointCmd(clientset *kubernetes.Clientset, namespace string, container *corev1.Container, podSpec *corev1.PodSpec) ([]string, []string, error) {
-	podInfo := K8s{Namespace: namespace, clientset: clientset}
-
-	err := podInfo.Load(container, podSpec)
+func GetEntrypointCmd(clientset kubernetes.Interface, namespace string, container *corev1.Container, podSpec *corev1.PodSpec) ([]string, []string, error) {
+	ref, err := ParseContainerImage(container.Image)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if podInfo.RegistryName != "" {
-		logger.Info(
-			"Trimmed registry name from image name",
-			"registry", podInfo.RegistryName,
-			"image", podInfo.Image,
-		)
-		podInfo.Image = strings.TrimLeft(podInfo.Image, fmt.Sprintf("%s/", podInfo.RegistryName))
+	nodeOS, nodeArch := resolveNodePlatform(podSpec)
+
+	// AllowMissingCreds: pull anonymously when no pull secret matches, so
+	// public images keep working.
+	podInfo := K8s{Namespace: namespace, clientset: clientset, RegistryName: ref.Registry, AllowMissingCreds: true, NodeOS: nodeOS, NodeArch: nodeArch}
+
+	if err := podInfo.Load(container, podSpec); err != nil {
+		return nil, nil, err
 	}
 
 	registryAddress := podInfo.RegistryAddress
@@ -131,26 +174,35 @@ func GetEntrypointCmd(clientset *kubernetes.Clientset, namespace string, contain
 		registryAddress = "https://registry-1.docker.io/"
 	}
 
-	// this is a library image on DockerHub, add the `libarary/` prefix
-	if isDockerHub(registryAddress) && strings.Count(podInfo.Image, "/") == 0 {
-		podInfo.Image = "library/" + podInfo.Image
-	}
-
 	logger.Infoln("I'm using registry", registryAddress)
 
-	return GetImageBlob(registryAddress, podInfo.RegistryUsername, podInfo.RegistryPassword, podInfo.Image)
+	return getImageBlobForPlatform(registryAddress, podInfo.RegistryUsername, podInfo.RegistryPassword, ref.String(), podInfo.NodeOS, podInfo.NodeArch)
 }
 
 // K8s structure keeps information retrieved from POD definition
 type K8s struct {
-	clientset        *kubernetes.Clientset
+	clientset        kubernetes.Interface
 	Namespace        string
-	ImagePullSecrets string
+	ImagePullSecrets []string
 	RegistryAddress  string
 	RegistryName     string
 	RegistryUsername string
 	RegistryPassword string
 	Image            string
+
+	// NodeOS and NodeArch are the platform GetEntrypointCmd resolved the pod
+	// onto, for multi-arch manifest list/OCI index selection.
+	NodeOS   string
+	NodeArch string
+
+	// CredHelper, when set, overrides any credsStore/credHelpers found in
+	// imagePullSecrets and forces resolution through docker-credential-<name>.
+	CredHelper string
+
+	// AllowMissingCreds makes Load return nil, leaving RegistryUsername and
+	// RegistryPassword empty, instead of an error when no credentials can be
+	// resolved for the image's registry.
+	AllowMissingCreds bool
 }
 
 func (k *K8s) readDockerSecret(namespace, secretName string) (map[string][]byte, error) {
@@ -161,43 +213,105 @@ func (k *K8s) readDockerSecret(namespace, secretName string) (map[string][]byte,
 	return secret.Data, nil
 }
 
-func (k *K8s) parseDockerConfig(dockerCreds DockerCreds) {
-	k.RegistryName = reflect.ValueOf(dockerCreds.Auths).MapKeys()[0].String()
+// readServiceAccountPullSecrets returns the imagePullSecrets attached to
+// the pod's ServiceAccount.
+func (k *K8s) readServiceAccountPullSecrets(serviceAccountName string) ([]string, error) {
+	if serviceAccountName == "" {
+		return nil, nil
+	}
+
+	sa, err := k.clientset.CoreV1().ServiceAccounts(k.Namespace).Get(serviceAccountName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read serviceAccount %q: %s", serviceAccountName, err.Error())
+	}
+
+	names := make([]string, 0, len(sa.ImagePullSecrets))
+	for _, ref := range sa.ImagePullSecrets {
+		names = append(names, ref.Name)
+	}
+
+	return names, nil
+}
+
+// resolveCredEntry turns a (possibly helper-backed) credEntry into a
+// concrete username/password, applying AllowMissingCreds on helper failure.
+func (k *K8s) resolveCredEntry(entry credEntry) error {
+	if entry.Helper == "" {
+		k.RegistryUsername = entry.Username
+		k.RegistryPassword = entry.Password
+		return nil
+	}
+
+	username, password, err := execCredentialHelper(entry.Helper, k.RegistryName)
+	if err != nil {
+		if k.AllowMissingCreds {
+			logger.WithFields(log.Fields{"helper": entry.Helper, "error": err.Error()}).Info("credential helper lookup failed, continuing without credentials")
+			return nil
+		}
+		return fmt.Errorf("cannot resolve credentials from helper %q: %s", entry.Helper, err.Error())
+	}
+
+	k.RegistryUsername = username
+	k.RegistryPassword = password
+	return nil
+}
+
+// Load reads information from k8s and load them into the structure
+func (k *K8s) Load(container *corev1.Container, podSpec *corev1.PodSpec) error {
+	k.Image = container.Image
+
 	if !strings.HasPrefix(k.RegistryName, "https://") {
 		k.RegistryAddress = fmt.Sprintf("https://%s", k.RegistryName)
 	} else {
 		k.RegistryAddress = k.RegistryName
 	}
 
-	auths := dockerCreds.Auths
-	k.RegistryUsername = auths[k.RegistryName].Username
-	k.RegistryPassword = auths[k.RegistryName].Password
-}
+	if k.CredHelper != "" {
+		return k.resolveCredEntry(credEntry{Helper: k.CredHelper})
+	}
 
-// Load reads information from k8s and load them into the structure
-func (k *K8s) Load(container *corev1.Container, podSpec *corev1.PodSpec) error {
+	secretNames := make([]string, 0, len(podSpec.ImagePullSecrets))
+	for _, ref := range podSpec.ImagePullSecrets {
+		secretNames = append(secretNames, ref.Name)
+	}
 
-	k.Image = container.Image
+	saSecretNames, err := k.readServiceAccountPullSecrets(podSpec.ServiceAccountName)
+	if err != nil {
+		return err
+	}
+	secretNames = append(secretNames, saSecretNames...)
 
-	if len(podSpec.ImagePullSecrets) >= 1 {
-		k.ImagePullSecrets = podSpec.ImagePullSecrets[0].Name
+	k.ImagePullSecrets = secretNames
 
-		if k.ImagePullSecrets != "" {
-			data, err := k.readDockerSecret(k.Namespace, k.ImagePullSecrets)
-			if err != nil {
-				return fmt.Errorf("cannot read imagePullSecrets: %s", err.Error())
-			}
+	combined := map[string]credEntry{}
+	for _, name := range secretNames {
+		if name == "" {
+			continue
+		}
 
-			dockerConfig := data[corev1.DockerConfigJsonKey]
+		data, err := k.readDockerSecret(k.Namespace, name)
+		if err != nil {
+			return fmt.Errorf("cannot read imagePullSecrets: %s", err.Error())
+		}
 
-			var dockerCreds DockerCreds
-			err = json.Unmarshal(dockerConfig, &dockerCreds)
-			if err != nil {
-				return fmt.Errorf("cannot unmarshal docker configuration from imagePullSecrets: %s", err.Error())
-			}
-			k.parseDockerConfig(dockerCreds)
+		var dockerCreds DockerCreds
+		if err := json.Unmarshal(data[corev1.DockerConfigJsonKey], &dockerCreds); err != nil {
+			return fmt.Errorf("cannot unmarshal docker configuration from imagePullSecrets: %s", err.Error())
 		}
+
+		mergeDockerCreds(combined, dockerCreds)
 	}
 
-	return nil
-}
\ No newline at end of file
+	entry, ok := combined[normalizeRegistryHost(k.RegistryName)]
+	if !ok {
+		entry, ok = combined[anyHost]
+	}
+	if !ok {
+		if k.AllowMissingCreds {
+			return nil
+		}
+		return &CredentialsNotFoundError{Registry: k.RegistryName}
+	}
+
+	return k.resolveCredEntry(entry)
+}
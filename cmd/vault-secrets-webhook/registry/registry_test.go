@@ -0,0 +1,91 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func dockerConfigSecret(name, namespace string, creds DockerCreds) *corev1.Secret {
+	raw, err := json.Marshal(creds)
+	if err != nil {
+		panic(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: raw},
+	}
+}
+
+func TestK8sLoad(t *testing.T) {
+	t.Run("merges pod imagePullSecrets with the ServiceAccount's", func(t *testing.T) {
+		podSecret := dockerConfigSecret("pod-pull-secret", "default", DockerCreds{
+			Auths: map[string]dockerTypes.AuthConfig{
+				"other.example.com": {Username: "irrelevant", Password: "irrelevant"},
+			},
+		})
+		saSecret := dockerConfigSecret("sa-pull-secret", "default", DockerCreds{
+			Auths: map[string]dockerTypes.AuthConfig{
+				"gcr.io": {Username: "sa-user", Password: "sa-pass"},
+			},
+		})
+		serviceAccount := &corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "default"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "sa-pull-secret"}},
+		}
+
+		clientset := fake.NewSimpleClientset(podSecret, saSecret, serviceAccount)
+		k := K8s{clientset: clientset, Namespace: "default", RegistryName: "gcr.io"}
+
+		podSpec := &corev1.PodSpec{
+			ServiceAccountName: "default",
+			ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "pod-pull-secret"}},
+		}
+		container := &corev1.Container{Image: "gcr.io/distroless/static:latest"}
+
+		if err := k.Load(container, podSpec); err != nil {
+			t.Fatalf("Load() returned unexpected error: %s", err)
+		}
+		if k.RegistryUsername != "sa-user" || k.RegistryPassword != "sa-pass" {
+			t.Errorf("RegistryUsername/RegistryPassword = %q/%q, want sa-user/sa-pass", k.RegistryUsername, k.RegistryPassword)
+		}
+	})
+
+	t.Run("no matching secret and AllowMissingCreds false returns CredentialsNotFoundError", func(t *testing.T) {
+		podSecret := dockerConfigSecret("pod-pull-secret", "default", DockerCreds{
+			Auths: map[string]dockerTypes.AuthConfig{
+				"other.example.com": {Username: "user", Password: "pass"},
+			},
+		})
+
+		clientset := fake.NewSimpleClientset(podSecret)
+		k := K8s{clientset: clientset, Namespace: "default", RegistryName: "gcr.io", AllowMissingCreds: false}
+
+		podSpec := &corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pod-pull-secret"}}}
+		container := &corev1.Container{Image: "gcr.io/distroless/static:latest"}
+
+		err := k.Load(container, podSpec)
+		if _, ok := err.(*CredentialsNotFoundError); !ok {
+			t.Fatalf("Load() error = %v (%T), want *CredentialsNotFoundError", err, err)
+		}
+	})
+}
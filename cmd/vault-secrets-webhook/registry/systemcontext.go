@@ -0,0 +1,135 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/containers/image/v5/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIImageIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// rawManifestList is a minimal, format-agnostic decode of a Docker manifest
+// list or OCI image index - both share this shape for the fields we need.
+type rawManifestList struct {
+	Manifests []struct {
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// availablePlatforms lists the os/architecture pairs present in a manifest
+// list/OCI index, for folding into an error message when none of them
+// matched the target node. Returns nil for anything else (including a
+// single-platform manifest, or a document it can't parse).
+func availablePlatforms(manifestBytes []byte, mediaType string) []string {
+	if mediaType != mediaTypeDockerManifestList && mediaType != mediaTypeOCIImageIndex {
+		return nil
+	}
+
+	var list rawManifestList
+	if err := json.Unmarshal(manifestBytes, &list); err != nil {
+		return nil
+	}
+
+	platforms := make([]string, 0, len(list.Manifests))
+	for _, m := range list.Manifests {
+		if m.Platform.OS == "" && m.Platform.Architecture == "" {
+			continue
+		}
+		platforms = append(platforms, fmt.Sprintf("%s/%s", m.Platform.OS, m.Platform.Architecture))
+	}
+
+	return platforms
+}
+
+// resolveNodePlatform returns the (os, architecture) pair workloads on
+// this pod are expected to run under, read from the pod's NodeSelector or
+// node affinity when present, falling back to linux on the webhook's own
+// architecture.
+func resolveNodePlatform(podSpec *corev1.PodSpec) (nodeOS, nodeArch string) {
+	nodeOS, nodeArch = "linux", runtime.GOARCH
+
+	if podSpec == nil {
+		return nodeOS, nodeArch
+	}
+
+	if v, ok := podSpec.NodeSelector["kubernetes.io/os"]; ok {
+		nodeOS = v
+	}
+	if v, ok := podSpec.NodeSelector["kubernetes.io/arch"]; ok {
+		nodeArch = v
+	}
+
+	if podSpec.Affinity == nil || podSpec.Affinity.NodeAffinity == nil {
+		return nodeOS, nodeArch
+	}
+
+	required := podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		return nodeOS, nodeArch
+	}
+
+	for _, term := range required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Operator != corev1.NodeSelectorOpIn || len(expr.Values) == 0 {
+				continue
+			}
+			switch expr.Key {
+			case "kubernetes.io/os":
+				nodeOS = expr.Values[0]
+			case "kubernetes.io/arch":
+				nodeArch = expr.Values[0]
+			}
+		}
+	}
+
+	return nodeOS, nodeArch
+}
+
+// buildSystemContext assembles the types.SystemContext that drives every
+// containers/image lookup. nodeOS/nodeArch pick the instance out of a
+// multi-arch manifest list/OCI index. Leaving AuthFilePath/RegistriesConfPath
+// unset lets the library fall back to its own defaults (REGISTRY_AUTH_FILE,
+// ~/.docker/config.json, registries.conf and its mirrors).
+func buildSystemContext(username, password, nodeOS, nodeArch string) *types.SystemContext {
+	sysCtx := &types.SystemContext{
+		OSChoice:           nodeOS,
+		ArchitectureChoice: nodeArch,
+	}
+
+	if username != "" {
+		sysCtx.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: username,
+			Password: password,
+		}
+	}
+
+	if os.Getenv("REGISTRY_SKIP_VERIFY") == "true" {
+		sysCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+
+	return sysCtx
+}